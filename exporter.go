@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	querySamplesDesc = prometheus.NewDesc(
+		"myprofiler_query_samples_total",
+		"Number of times a normalized query was observed.",
+		[]string{"digest", "fingerprint"}, nil,
+	)
+	topNDesc = prometheus.NewDesc(
+		"myprofiler_top_n",
+		"Configured number of top queries shown/exported.",
+		nil, nil,
+	)
+	sampleIntervalDesc = prometheus.NewDesc(
+		"myprofiler_sample_interval_seconds",
+		"Configured sampling interval in seconds.",
+		nil, nil,
+	)
+)
+
+// queryCollector implements prometheus.Collector by pulling a fresh
+// Snapshot from summ on every scrape.
+type queryCollector struct {
+	summ     Summarizer
+	topN     int
+	interval float64
+	// rolling is true when summ is a recentSummarizer, whose per-digest
+	// count can decrease as old samples age out of its window. Such a
+	// count isn't a valid Prometheus counter, so it's exported as a gauge
+	// instead.
+	rolling bool
+}
+
+func (c *queryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- querySamplesDesc
+	ch <- topNDesc
+	ch <- sampleIntervalDesc
+}
+
+func (c *queryCollector) Collect(ch chan<- prometheus.Metric) {
+	valueType := prometheus.CounterValue
+	if c.rolling {
+		valueType = prometheus.GaugeValue
+	}
+	for _, qc := range c.summ.Snapshot(c.topN) {
+		ch <- prometheus.MustNewConstMetric(
+			querySamplesDesc, valueType, float64(qc.c),
+			qc.q, fingerprintOf(qc.q),
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(topNDesc, prometheus.GaugeValue, float64(c.topN))
+	ch <- prometheus.MustNewConstMetric(sampleIntervalDesc, prometheus.GaugeValue, c.interval)
+}
+
+// fingerprintOf returns the hex xxhash fingerprint of an already-normalized
+// digest.
+func fingerprintOf(digest string) string {
+	return fmt.Sprintf("%x", xxhash.Sum64String(digest))
+}
+
+func queriesHandler(summ Summarizer, topN int, interval float64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(toRecords(summ.Snapshot(topN), interval)); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// serveMetrics starts an HTTP server on listen exposing summ as Prometheus
+// metrics under /metrics and as JSON under /queries. It blocks.
+func serveMetrics(listen string, summ Summarizer, cfg *Config) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&queryCollector{summ: summ, topN: cfg.topN, interval: cfg.interval, rolling: cfg.last > 0})
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/queries", queriesHandler(summ, cfg.topN, cfg.interval))
+
+	log.Printf("Serving Prometheus metrics on %s", listen)
+	if err := http.ListenAndServe(listen, mux); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const timeLayout = "2006-01-02 15:04:05.00 -0700"
+
+// record is the structured shape written for each query in json/ndjson/csv
+// output and returned by the /queries endpoint.
+type record struct {
+	Count       int64   `json:"count"`
+	Digest      string  `json:"digest"`
+	Fingerprint string  `json:"fingerprint"`
+	FirstSeen   string  `json:"first_seen"`
+	LastSeen    string  `json:"last_seen"`
+	Interval    float64 `json:"interval"`
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(timeLayout)
+}
+
+func toRecords(counts []queryCount, interval float64) []record {
+	records := make([]record, 0, len(counts))
+	for _, qc := range counts {
+		records = append(records, record{
+			Count:       qc.c,
+			Digest:      qc.q,
+			Fingerprint: fingerprintOf(qc.q),
+			FirstSeen:   formatTime(qc.firstSeen),
+			LastSeen:    formatTime(qc.lastSeen),
+			Interval:    interval,
+		})
+	}
+	return records
+}
+
+// renderSummary writes counts to w as requested by format: the original
+// "%4d query" text (the default), a single JSON array, one JSON object per
+// line (ndjson), or CSV with a header row.
+func renderSummary(w io.Writer, counts []queryCount, format string, interval float64) error {
+	switch format {
+	case "", "text":
+		for _, qc := range counts {
+			if _, err := fmt.Fprintf(w, "%4d %s\n", qc.c, qc.q); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "json":
+		return json.NewEncoder(w).Encode(toRecords(counts, interval))
+
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, rec := range toRecords(counts, interval) {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"count", "digest", "fingerprint", "first_seen", "last_seen", "interval"}); err != nil {
+			return err
+		}
+		for _, rec := range toRecords(counts, interval) {
+			err := cw.Write([]string{
+				strconv.FormatInt(rec.Count, 10),
+				rec.Digest,
+				rec.Fingerprint,
+				rec.FirstSeen,
+				rec.LastSeen,
+				strconv.FormatFloat(rec.Interval, 'f', -1, 64),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		return fmt.Errorf("unknown -format %q, must be one of text, json, ndjson, csv", format)
+	}
+}
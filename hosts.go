@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/sjmudd/mysql_defaults_file"
+	"gopkg.in/yaml.v3"
+)
+
+// HostConfig describes one MySQL target to profile. Several can be given
+// at once via repeated -host flags or a -hosts=file.yaml.
+type HostConfig struct {
+	Host     string `yaml:"host"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Port     int    `yaml:"port"`
+}
+
+// hostFlag implements flag.Value so `-host` can be repeated on the command
+// line to profile several servers in one run.
+type hostFlag []string
+
+func (h *hostFlag) String() string {
+	if h == nil {
+		return ""
+	}
+	return fmt.Sprint([]string(*h))
+}
+
+func (h *hostFlag) Set(v string) error {
+	*h = append(*h, v)
+	return nil
+}
+
+// loadHostConfigs resolves the set of hosts to profile. A -hosts=file.yaml
+// takes precedence over repeated -host flags; entries left blank in the
+// YAML file fall back to the -user/-password/-port flags. With neither
+// given, it returns a single zero-value HostConfig (host "" meaning
+// "localhost, ~/.my.cnf defaults").
+func loadHostConfigs(hostsFile string, hosts []string, dbuser, password string, port int) ([]HostConfig, error) {
+	if hostsFile != "" {
+		data, err := os.ReadFile(hostsFile)
+		if err != nil {
+			return nil, err
+		}
+		var configs []HostConfig
+		if err := yaml.Unmarshal(data, &configs); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", hostsFile, err)
+		}
+		if len(configs) == 0 {
+			return nil, fmt.Errorf("no hosts in %s", hostsFile)
+		}
+		for i := range configs {
+			if configs[i].User == "" {
+				configs[i].User = dbuser
+			}
+			if configs[i].Password == "" {
+				configs[i].Password = password
+			}
+			if configs[i].Port == 0 {
+				configs[i].Port = port
+			}
+		}
+		return configs, nil
+	}
+
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	configs := make([]HostConfig, len(hosts))
+	for i, h := range hosts {
+		configs[i] = HostConfig{Host: h, User: dbuser, Password: password, Port: port}
+	}
+	return configs, nil
+}
+
+// ConnOptions holds the connection settings shared by every host in a
+// single myprofiler run, as opposed to HostConfig's per-host fields.
+type ConnOptions struct {
+	Socket string
+	// TLSParam is the resolved value of the DSN's tls= parameter: "",
+	// "true", "skip-verify", or the name a custom tls.Config was
+	// registered under via mysql.RegisterTLSConfig.
+	TLSParam string
+}
+
+// openDB builds a *sql.DB for hc: start from ~/.my.cnf if present, then
+// apply any command-line overrides, then fall back to localhost / the
+// current OS user.
+func openDB(hc HostConfig, opts ConnOptions) (*sql.DB, error) {
+	var config mysql_defaults_file.Config
+	if _, err := os.Stat(os.Getenv("HOME") + "/.my.cnf"); err == nil {
+		config = mysql_defaults_file.NewConfig("")
+	}
+
+	if hc.Host != "" {
+		config.Host = hc.Host
+	}
+	if hc.User != "" {
+		config.User = hc.User
+	}
+	if hc.Password != "" {
+		config.Password = hc.Password
+	} else if os.Getenv("MYSQL_PWD") != "" {
+		config.Password = os.Getenv("MYSQL_PWD")
+	}
+	if hc.Port != 0 {
+		config.Port = uint16(hc.Port)
+	}
+	if opts.Socket != "" {
+		config.Socket = opts.Socket
+	}
+
+	if config.Host == "" {
+		config.Host = "localhost"
+	}
+	if config.User == "" {
+		currentUser, err := user.Current()
+		if err == nil {
+			config.User = currentUser.Name
+		}
+	}
+	config.Host = bracketIPv6(config.Host)
+
+	dsn := mysql_defaults_file.BuildDSN(config, "")
+	if opts.TLSParam != "" {
+		dsn += "&tls=" + opts.TLSParam
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("dsn %q: %w", dsn, err)
+	}
+	return db, nil
+}
+
+// bracketIPv6 wraps an IPv6 literal host in brackets the way a `tcp(...)`
+// DSN address requires, e.g. "::1" becomes "[::1]".
+func bracketIPv6(host string) string {
+	if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// openDumpFile creates the raw-query dump file for host. When profiling a
+// single host it is exactly dumpfile; with several hosts each gets its own
+// file, named "<dumpfile>.<host>".
+func openDumpFile(dumpfile, host string, multiHost bool) (io.Writer, error) {
+	path := dumpfile
+	if multiHost {
+		path = fmt.Sprintf("%s.%s", dumpfile, strings.NewReplacer("/", "_", ":", "_").Replace(host))
+	}
+	return os.Create(path)
+}
@@ -2,18 +2,18 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"os/user"
-	"regexp"
 	"sort"
+	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/sjmudd/mysql_defaults_file"
 )
 
 type Config struct {
@@ -22,26 +22,7 @@ type Config struct {
 	last     int
 	interval float64
 	delay    int
-}
-
-type NormalizePattern struct {
-	re   *regexp.Regexp
-	subs string
-}
-
-func (p *NormalizePattern) Normalize(q string) string {
-	return p.re.ReplaceAllString(q, p.subs)
-}
-
-var normalizePatterns = []NormalizePattern{
-	{regexp.MustCompile(` +`), " "},
-	{regexp.MustCompile(`[+\-]?\b\d+\b`), "N"},
-	{regexp.MustCompile(`\b0x[0-9A-Fa-f]+\b`), "0xN"},
-	{regexp.MustCompile(`(\\')`), ""},
-	{regexp.MustCompile(`(\\")`), ""},
-	{regexp.MustCompile(`'[^']+'`), "S"},
-	{regexp.MustCompile(`"[^"]+"`), "S"},
-	{regexp.MustCompile(`(([NS]\s*,\s*){4,})`), "..."},
+	format   string
 }
 
 func processList(db *sql.DB) []string {
@@ -89,16 +70,18 @@ func processList(db *sql.DB) []string {
 	return queries
 }
 
+var defaultNormalizer = NewNormalizer()
+
 func normalizeQuery(query string) string {
-	for _, pat := range normalizePatterns {
-		query = pat.Normalize(query)
-	}
-	return query
+	text, _ := defaultNormalizer.Digest(query)
+	return text
 }
 
 type queryCount struct {
-	q string
-	c int64
+	q         string
+	c         int64
+	firstSeen time.Time
+	lastSeen  time.Time
 }
 type pairList []queryCount
 
@@ -114,54 +97,72 @@ func (pl pairList) Swap(i, j int) {
 	pl[i], pl[j] = pl[j], pl[i]
 }
 
+// Summarizer accumulates normalized queries and reports the current top-N.
 type Summarizer interface {
-	Update(queries []string)
-	Show(out io.Writer, num int)
+	Update(queries []string, at time.Time)
+	Show(out io.Writer, num int, format string, interval float64) error
+	Snapshot(num int) []queryCount
 }
 
-func showSummary(w io.Writer, sum map[string]int64, n int) {
+func aggregate(sum map[string]*queryCount, n int) []queryCount {
 	counts := make([]queryCount, 0, len(sum))
-	for q, c := range sum {
-		counts = append(counts, queryCount{q, c})
+	for _, qc := range sum {
+		counts = append(counts, *qc)
 	}
 	sort.Sort(pairList(counts))
-
-	for i, p := range counts {
-		if i >= n {
-			break
-		}
-		_, err := fmt.Fprintf(w, "%4d %s\n", p.c, p.q)
-		if err != nil {
-			return
-		}
+	if n < len(counts) {
+		counts = counts[:n]
 	}
+	return counts
 }
 
 type summarizer struct {
-	counts map[string]int64
+	mu     sync.Mutex
+	counts map[string]*queryCount
 }
 
-func (s *summarizer) Update(queries []string) {
+func (s *summarizer) Update(queries []string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.counts == nil {
-		s.counts = make(map[string]int64)
+		s.counts = make(map[string]*queryCount)
 	}
 	for _, q := range queries {
-		s.counts[q]++
+		qc, ok := s.counts[q]
+		if !ok {
+			qc = &queryCount{q: q, firstSeen: at}
+			s.counts[q] = qc
+		}
+		qc.c++
+		qc.lastSeen = at
 	}
 }
 
-func (s *summarizer) Show(out io.Writer, num int) {
-	showSummary(out, s.counts, num)
+func (s *summarizer) Show(out io.Writer, num int, format string, interval float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return renderSummary(out, aggregate(s.counts, num), format, interval)
+}
+
+func (s *summarizer) Snapshot(num int) []queryCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return aggregate(s.counts, num)
 }
 
 type recentSummarizer struct {
+	mu     sync.Mutex
 	last   int
 	counts [][]queryCount
+	times  []time.Time
 }
 
-func (s *recentSummarizer) Update(queries []string) {
+func (s *recentSummarizer) Update(queries []string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if len(s.counts) >= s.last {
 		s.counts = s.counts[1:]
+		s.times = s.times[1:]
 	}
 	sort.Strings(queries)
 	qc := make([]queryCount, 0, 16)
@@ -173,126 +174,219 @@ func (s *recentSummarizer) Update(queries []string) {
 		}
 	}
 	s.counts = append(s.counts, qc)
+	s.times = append(s.times, at)
 }
 
-func (s *recentSummarizer) Show(out io.Writer, num int) {
-	sum := make(map[string]int64)
-	for _, qcs := range s.counts {
+// sum folds the stored sample batches into one map per digest, tracking the
+// earliest and latest batch timestamp each digest was seen in.
+func (s *recentSummarizer) sum() map[string]*queryCount {
+	sum := make(map[string]*queryCount)
+	for i, qcs := range s.counts {
+		at := s.times[i]
 		for _, qc := range qcs {
-			sum[qc.q] += qc.c
+			rec, ok := sum[qc.q]
+			if !ok {
+				rec = &queryCount{q: qc.q, firstSeen: at}
+				sum[qc.q] = rec
+			}
+			rec.c += qc.c
+			if at.Before(rec.firstSeen) {
+				rec.firstSeen = at
+			}
+			if at.After(rec.lastSeen) {
+				rec.lastSeen = at
+			}
 		}
 	}
-	showSummary(out, sum, num)
+	return sum
+}
+
+func (s *recentSummarizer) Show(out io.Writer, num int, format string, interval float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return renderSummary(out, aggregate(s.sum(), num), format, interval)
+}
+
+func (s *recentSummarizer) Snapshot(num int) []queryCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return aggregate(s.sum(), num)
 }
 
 func NewSummarizer(last int) Summarizer {
 	if last > 0 {
 		return &recentSummarizer{last: last}
 	}
-	return &summarizer{make(map[string]int64)}
+	return &summarizer{counts: make(map[string]*queryCount)}
 }
 
-func profile(db *sql.DB, cfg *Config) {
-	summ := NewSummarizer(cfg.last)
+// stdoutMu serializes the tag+Show sequence below across the per-host
+// goroutines main() spawns, so two hosts' summary blocks on stdout can
+// never interleave.
+var stdoutMu sync.Mutex
+
+// profile runs the sample/normalize/summarize loop for a single source.
+// tag, if non-empty, prefixes each printed summary; merged, if non-nil,
+// receives every update too. A source that returns io.EOF (such as -replay
+// reading a finite dump file) ends the loop after one final summary.
+func profile(src QuerySource, cfg *Config, summ Summarizer, tag string, merged Summarizer) {
 	cnt := 0
 	for {
-		queries := processList(db)
+		queries, err := src.Next()
+		done := errors.Is(err, io.EOF)
+		if err != nil && !done {
+			log.Println(err)
+		}
 		if cfg.dump != nil {
 			for _, q := range queries {
-				_, err := cfg.dump.Write([]byte(q))
+				// Queries are JSON-encoded one per line so a query with an
+				// embedded newline round-trips through -replay as a single
+				// record instead of splitting into several.
+				line, err := json.Marshal(q)
 				if err != nil {
-					return
+					log.Println(err)
+					continue
 				}
-				_, err2 := cfg.dump.Write([]byte{'\n'})
-				if err2 != nil {
+				if _, err := cfg.dump.Write(append(line, '\n')); err != nil {
 					return
 				}
 			}
 		}
 
+		now := time.Now()
 		for i, q := range queries {
 			queries[i] = normalizeQuery(q)
 		}
-		summ.Update(queries)
+		summ.Update(queries, now)
+		if merged != nil {
+			merged.Update(queries, now)
+		}
 
 		cnt++
-		if cnt >= cfg.delay {
+		if cnt >= cfg.delay || done {
 			cnt = 0
-			fmt.Println("## ", time.Now().Local().Format("2006-01-02 15:04:05.00 -0700"))
-			summ.Show(os.Stdout, cfg.topN)
+			stdoutMu.Lock()
+			if tag == "" {
+				fmt.Println("## ", now.Local().Format(timeLayout))
+			} else {
+				fmt.Printf("## %s %s\n", tag, now.Local().Format(timeLayout))
+			}
+			if err := summ.Show(os.Stdout, cfg.topN, cfg.format, cfg.interval); err != nil {
+				log.Println(err)
+			}
+			stdoutMu.Unlock()
 		}
 
+		if done {
+			return
+		}
 		time.Sleep(time.Duration(float64(time.Second) * cfg.interval))
 	}
 }
 
 func main() {
-	var host, dbuser, password, dumpfile string
+	var hosts hostFlag
+	var hostsFile, dbuser, password, dumpfile, source, slowlog, listen, replay string
+	var socket, tlsMode, tlsCA, tlsCert, tlsKey string
 	var port int
 
 	cfg := Config{}
-	flag.StringVar(&host, "host", "", "Host of database")
+	flag.Var(&hosts, "host", "Host of database, may be repeated to profile several hosts at once")
+	flag.StringVar(&hostsFile, "hosts", "", "YAML file listing hosts to profile, as an alternative to repeated -host flags")
 	flag.StringVar(&dbuser, "user", "", "User")
 	flag.StringVar(&password, "password", "", "Password")
 	flag.IntVar(&port, "port", 0, "Port")
-
-	flag.StringVar(&dumpfile, "dump", "", "Write raw queries to this file")
+	flag.StringVar(&socket, "socket", "", "Unix socket path to connect through, instead of -host/-port")
+	flag.StringVar(&tlsMode, "tls", "false", "TLS mode for the connection: false, true, skip-verify or custom")
+	flag.StringVar(&tlsCA, "tls-ca", "", "CA certificate file, used when -tls=custom")
+	flag.StringVar(&tlsCert, "tls-cert", "", "Client certificate file, used when -tls=custom")
+	flag.StringVar(&tlsKey, "tls-key", "", "Client key file, used when -tls=custom")
+
+	flag.StringVar(&dumpfile, "dump", "", "Write raw queries to this file (one per host when profiling several)")
+	flag.StringVar(&source, "source", "processlist", "Where to sample queries from: processlist, perf_schema or slowlog")
+	flag.StringVar(&slowlog, "slowlog", "", "Path to the slow-query log to tail, required when -source=slowlog")
+	flag.StringVar(&replay, "replay", "", "Replay queries from a file written by -dump instead of connecting to MySQL")
+	flag.StringVar(&listen, "listen", "", "Address to serve Prometheus metrics on, e.g. :9105. Disabled if empty")
 
 	flag.IntVar(&cfg.topN, "top", 10, "(int) Show N most common queries")
 	flag.IntVar(&cfg.last, "last", 0, "(int) Last N samples are summarized. 0 means summarize all samples")
 	flag.Float64Var(&cfg.interval, "interval", 1.0, "(float) Sampling interval")
 	flag.IntVar(&cfg.delay, "delay", 1, "(int) Show summary for each `delay` samples. -interval=0.1 -delay=30 shows summary for every 3sec")
+	flag.StringVar(&cfg.format, "format", "text", "Output format for summaries: text, json, ndjson or csv")
 
 	flag.Parse()
 
-	// Initialize MySQL connection configuration
-	// First try to load from ~/.my.cnf if it exists
-	var config mysql_defaults_file.Config
-	if _, err := os.Stat(os.Getenv("HOME") + "/.my.cnf"); err == nil {
-		config = mysql_defaults_file.NewConfig("")
+	if replay != "" {
+		src, err := newReplaySource(replay)
+		if err != nil {
+			log.Fatal(err)
+		}
+		profile(src, &cfg, NewSummarizer(cfg.last), "", nil)
+		return
 	}
 
-	// Override with command line parameters if provided
-	if host != "" {
-		config.Host = host
-	}
-	if dbuser != "" {
-		config.User = dbuser
+	hostConfigs, err := loadHostConfigs(hostsFile, hosts, dbuser, password, port)
+	if err != nil {
+		log.Fatal(err)
 	}
-	if password != "" {
-		config.Password = password
-	} else if os.Getenv("MYSQL_PWD") != "" {
-		config.Password = os.Getenv("MYSQL_PWD")
+	if !needsDB(source) && len(hostConfigs) > 1 {
+		log.Fatalf("-source=%s does not read from a host, so -host/-hosts may only be given once", source)
 	}
-	if port != 0 {
-		config.Port = uint16(port)
+	multiHost := len(hostConfigs) > 1
+
+	tlsParam, err := resolveTLS(tlsMode, tlsCA, tlsCert, tlsKey)
+	if err != nil {
+		log.Fatal(err)
 	}
+	connOpts := ConnOptions{Socket: socket, TLSParam: tlsParam}
 
-	// Set defaults for required fields if not provided
-	if config.Host == "" {
-		config.Host = "localhost"
+	var merged Summarizer
+	if multiHost {
+		merged = NewSummarizer(cfg.last)
 	}
-	if config.User == "" {
-		currentUser, err := user.Current()
-		if err == nil {
-			config.User = currentUser.Name
+
+	var wg sync.WaitGroup
+	for _, hc := range hostConfigs {
+		var db *sql.DB
+		if needsDB(source) {
+			db, err = openDB(hc, connOpts)
+			if err != nil {
+				log.Fatal(err)
+			}
 		}
-	}
 
-	// Build the DSN (Data Source Name) for MySQL connection
-	dsn := mysql_defaults_file.BuildDSN(config, "")
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		fmt.Println("dsn: ", dsn)
-		log.Fatal(err)
-	}
+		hostCfg := cfg
+		if dumpfile != "" {
+			file, err := openDumpFile(dumpfile, hc.Host, multiHost)
+			if err != nil {
+				log.Fatal(err)
+			}
+			hostCfg.dump = file
+		}
 
-	if dumpfile != "" {
-		file, err := os.Create(dumpfile)
+		src, err := newQuerySource(source, slowlog, db)
 		if err != nil {
 			log.Fatal(err)
 		}
-		cfg.dump = file
+
+		summ := NewSummarizer(cfg.last)
+		tag := hc.Host
+		updateMerged := merged
+		if !multiHost {
+			tag = ""
+			updateMerged = nil
+			merged = summ
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			profile(src, &hostCfg, summ, tag, updateMerged)
+		}()
+	}
+
+	if listen != "" {
+		go serveMetrics(listen, merged, &cfg)
 	}
-	profile(db, &cfg)
+	wg.Wait()
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Normalizer turns a raw SQL statement into a canonical digest text plus a
+// stable fingerprint, the same shape as performance_schema's DIGEST_TEXT /
+// DIGEST columns.
+type Normalizer struct{}
+
+func NewNormalizer() *Normalizer {
+	return &Normalizer{}
+}
+
+// Digest tokenizes q and returns its canonical text together with a 64-bit
+// fingerprint (xxhash of that text).
+func (n *Normalizer) Digest(q string) (text string, hash uint64) {
+	tokens := collapsePlaceholderLists(tokenize(q))
+	text = joinTokens(tokens)
+	return text, xxhash.Sum64String(text)
+}
+
+type tokenKind int
+
+const (
+	tokOther tokenKind = iota
+	tokPlaceholder
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize scans a raw SQL statement into a sequence of tokens. String,
+// numeric and hex literals are recognized as placeholders ("?") and
+// comments are dropped entirely.
+func tokenize(q string) []token {
+	r := []rune(q)
+	n := len(r)
+	var tokens []token
+	i := 0
+
+	for i < n {
+		c := r[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '-' && i+1 < n && r[i+1] == '-':
+			for i < n && r[i] != '\n' {
+				i++
+			}
+
+		case c == '#':
+			for i < n && r[i] != '\n' {
+				i++
+			}
+
+		case c == '/' && i+1 < n && r[i+1] == '*':
+			i += 2
+			for i+1 < n && !(r[i] == '*' && r[i+1] == '/') {
+				i++
+			}
+			i = min(i+2, n)
+
+		case c == '`':
+			start := i
+			i++
+			for i < n {
+				if r[i] == '`' {
+					if i+1 < n && r[i+1] == '`' {
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				i++
+			}
+			tokens = append(tokens, token{tokOther, string(r[start:i])})
+
+		case c == '\'' || c == '"':
+			quote := c
+			i++
+			for i < n {
+				if r[i] == '\\' && i+1 < n {
+					i += 2
+					continue
+				}
+				if r[i] == quote {
+					if i+1 < n && r[i+1] == quote {
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				i++
+			}
+			tokens = append(tokens, token{tokPlaceholder, "?"})
+
+		case c == '0' && i+1 < n && (r[i+1] == 'x' || r[i+1] == 'X') && i+2 < n && isHexDigit(r[i+2]):
+			i += 2
+			for i < n && isHexDigit(r[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokPlaceholder, "?"})
+
+		case isDigit(c):
+			i++
+			for i < n {
+				if isDigit(r[i]) || r[i] == '.' {
+					i++
+					continue
+				}
+				if (r[i] == 'e' || r[i] == 'E') && i+1 < n && (isDigit(r[i+1]) || r[i+1] == '+' || r[i+1] == '-') {
+					i++ // consume 'e'/'E'
+					if r[i] == '+' || r[i] == '-' {
+						i++ // consume the exponent sign too
+					}
+					continue
+				}
+				break
+			}
+			tokens = append(tokens, token{tokPlaceholder, "?"})
+
+		case isIdentStart(c):
+			start := i
+			for i < n && isIdentPart(r[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokOther, string(r[start:i])})
+
+		default:
+			tokens = append(tokens, token{tokOther, string(c)})
+			i++
+		}
+	}
+	return tokens
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c rune) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isIdentStart(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c > 127
+}
+
+func isIdentPart(c rune) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+// collapsePlaceholderLists folds runs of four or more comma-separated
+// placeholders (e.g. an `IN (1, 2, 3, 4, 5)` list) down to a single "?, ...".
+func collapsePlaceholderLists(tokens []token) []token {
+	out := make([]token, 0, len(tokens))
+	i := 0
+	for i < len(tokens) {
+		if tokens[i].kind != tokPlaceholder {
+			out = append(out, tokens[i])
+			i++
+			continue
+		}
+		j := i + 1
+		count := 1
+		for j+1 < len(tokens) && tokens[j].text == "," && tokens[j+1].kind == tokPlaceholder {
+			count++
+			j += 2
+		}
+		if count >= 4 {
+			out = append(out, token{tokPlaceholder, "?"}, token{tokOther, ","}, token{tokOther, "..."})
+			i = j
+			continue
+		}
+		out = append(out, tokens[i])
+		i++
+	}
+	return out
+}
+
+// joinTokens renders tokens back into readable SQL text, keeping the usual
+// "no space before a comma/closing paren" conventions.
+func joinTokens(tokens []token) string {
+	var sb strings.Builder
+	for idx, t := range tokens {
+		if idx > 0 {
+			prev := tokens[idx-1].text
+			if t.text != "," && t.text != ")" && t.text != ";" && t.text != "." && prev != "(" && prev != "." {
+				sb.WriteByte(' ')
+			}
+		}
+		sb.WriteString(t.text)
+	}
+	return sb.String()
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestNormalizerDigest(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"single quote escape", `SELECT * FROM t WHERE name = 'O\'Brien'`, "SELECT * FROM t WHERE name = ?"},
+		{"doubled single quote escape", `SELECT * FROM t WHERE name = 'O''Brien'`, "SELECT * FROM t WHERE name = ?"},
+		{"double quote string", `SELECT * FROM t WHERE name = "O'Brien"`, "SELECT * FROM t WHERE name = ?"},
+		{"backtick identifier", "SELECT `a` FROM `t`", "SELECT `a` FROM `t`"},
+		{"doubled backtick escape", "SELECT `a``b` FROM `t`", "SELECT `a``b` FROM `t`"},
+		{"line comment dash", "SELECT 1 -- trailing comment\nFROM t", "SELECT ? FROM t"},
+		{"line comment hash", "SELECT 1 # trailing comment\nFROM t", "SELECT ? FROM t"},
+		{"block comment", "SELECT /* inline */ 1 FROM t", "SELECT ? FROM t"},
+		{"hex literal", "SELECT * FROM t WHERE id = 0x1F", "SELECT * FROM t WHERE id = ?"},
+		{"integer literal", "SELECT * FROM t WHERE id = 42", "SELECT * FROM t WHERE id = ?"},
+		{"float literal", "SELECT * FROM t WHERE f = 1.5e-10", "SELECT * FROM t WHERE f = ?"},
+		{"short in list kept", "SELECT * FROM t WHERE id IN (1, 2)", "SELECT * FROM t WHERE id IN (?, ?)"},
+		{"long in list collapsed", "SELECT * FROM t WHERE id IN (1, 2, 3, 4, 5)", "SELECT * FROM t WHERE id IN (?, ...)"},
+	}
+
+	n := NewNormalizer()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, _ := n.Digest(c.in)
+			if got != c.want {
+				t.Errorf("Digest(%q) = %q, want %q", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestNormalizerDigestEquivalence(t *testing.T) {
+	pairs := [][2]string{
+		{`SELECT * FROM t WHERE name = 'O\'Brien'`, `SELECT * FROM t WHERE name = 'O''Brien'`},
+		{"SELECT * FROM t WHERE id = 1", "SELECT * FROM t WHERE id = 999"},
+	}
+
+	n := NewNormalizer()
+	for _, p := range pairs {
+		textA, hashA := n.Digest(p[0])
+		textB, hashB := n.Digest(p[1])
+		if textA != textB || hashA != hashB {
+			t.Errorf("expected %q and %q to share a digest, got %q (%x) vs %q (%x)", p[0], p[1], textA, hashA, textB, hashB)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// replayMaxLineSize is the largest single dumped query -replay will accept,
+// well above bufio.Scanner's 64KB default so a bulk INSERT or ORM-generated
+// statement in the dump file doesn't fail the scan partway through the file.
+const replayMaxLineSize = 64 << 20
+
+// QuerySource yields the raw SQL text observed since the previous call to
+// Next.
+type QuerySource interface {
+	Next() ([]string, error)
+}
+
+// processListSource samples `SHOW FULL PROCESSLIST` once per call to Next.
+// This is the original, default sampling backend.
+type processListSource struct {
+	db *sql.DB
+}
+
+func (s *processListSource) Next() ([]string, error) {
+	return processList(s.db), nil
+}
+
+// perfSchemaSource reads completed statements from
+// performance_schema.events_statements_history. Unlike PROCESSLIST polling
+// it does not miss queries that finish between samples, since every
+// statement leaves a row behind until it ages out of the ring buffer.
+type perfSchemaSource struct {
+	db           *sql.DB
+	lastTimerEnd uint64
+}
+
+func (s *perfSchemaSource) Next() ([]string, error) {
+	rows, err := s.db.Query(
+		`SELECT SQL_TEXT, TIMER_END FROM performance_schema.events_statements_history
+		 WHERE TIMER_END > ? AND SQL_TEXT IS NOT NULL
+		 ORDER BY TIMER_END`,
+		s.lastTimerEnd,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println("Error closing rows:", err)
+		}
+	}()
+
+	var queries []string
+	for rows.Next() {
+		var sqlText string
+		var timerEnd uint64
+		if err := rows.Scan(&sqlText, &timerEnd); err != nil {
+			log.Println(err)
+			continue
+		}
+		queries = append(queries, sqlText)
+		if timerEnd > s.lastTimerEnd {
+			s.lastTimerEnd = timerEnd
+		}
+	}
+	return queries, rows.Err()
+}
+
+// slowLogSource tails a MySQL slow-query log, parsing the multi-line
+// `# Time:` / `# Query_time:` entry format and returning one string per
+// completed statement.
+type slowLogSource struct {
+	path   string
+	file   *os.File
+	reader *bufio.Reader
+	query  strings.Builder
+	inStmt bool
+}
+
+// newSlowLogSource opens path and seeks to the end, so Next only ever
+// returns statements appended after the tool was started.
+func newSlowLogSource(path string) (*slowLogSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	return &slowLogSource{path: path, file: file, reader: bufio.NewReader(file)}, nil
+}
+
+// isSlowLogHeader reports whether line is one of the "# Key: value ..."
+// annotation lines the slow-query log writes before each statement, rather
+// than part of the statement text itself.
+func isSlowLogHeader(line string) bool {
+	return strings.HasPrefix(line, "# ") ||
+		strings.HasPrefix(line, "SET timestamp=") ||
+		strings.HasPrefix(line, "use ")
+}
+
+func (s *slowLogSource) flush(queries []string) []string {
+	if s.inStmt {
+		if q := strings.TrimSpace(s.query.String()); q != "" {
+			queries = append(queries, q)
+		}
+		s.query.Reset()
+		s.inStmt = false
+	}
+	return queries
+}
+
+// Next reads whatever has been appended to the slow log since the previous
+// call and returns the statements that have fully arrived. A statement that
+// is still being written (no later entry has started yet) is held back
+// until the next call.
+func (s *slowLogSource) Next() ([]string, error) {
+	var queries []string
+	for {
+		line, err := s.reader.ReadString('\n')
+		if len(line) > 0 {
+			if strings.HasPrefix(line, "# User@Host:") {
+				queries = s.flush(queries)
+			} else if !isSlowLogHeader(line) {
+				s.inStmt = true
+				s.query.WriteString(line)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return queries, err
+		}
+	}
+	return queries, nil
+}
+
+// replaySource feeds the normalize/summarize pipeline from a file written
+// by -dump, instead of reconnecting to MySQL. The whole file is read as a
+// single batch; Next returns io.EOF alongside it so profile() prints one
+// final summary and stops.
+type replaySource struct {
+	scanner *bufio.Scanner
+	done    bool
+}
+
+func newReplaySource(path string) (*replaySource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), replayMaxLineSize)
+	return &replaySource{scanner: scanner}, nil
+}
+
+// Next decodes each line as a JSON string, the format -dump writes, so a
+// query containing an embedded newline round-trips as a single record.
+func (s *replaySource) Next() ([]string, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+	s.done = true
+
+	var queries []string
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			continue
+		}
+		var q string
+		if err := json.Unmarshal([]byte(line), &q); err != nil {
+			log.Println(err)
+			continue
+		}
+		queries = append(queries, q)
+	}
+	if err := s.scanner.Err(); err != nil {
+		return queries, err
+	}
+	return queries, io.EOF
+}
+
+// availableSources lists the accepted values of -source, in the order they
+// should be tried/documented.
+var availableSources = []string{"processlist", "perf_schema", "slowlog"}
+
+func newQuerySource(source, slowlog string, db *sql.DB) (QuerySource, error) {
+	switch source {
+	case "processlist":
+		return &processListSource{db: db}, nil
+	case "perf_schema":
+		return &perfSchemaSource{db: db}, nil
+	case "slowlog":
+		if slowlog == "" {
+			return nil, fmt.Errorf("-source=slowlog requires -slowlog=/path/to/slow.log")
+		}
+		return newSlowLogSource(slowlog)
+	default:
+		return nil, fmt.Errorf("unknown -source %q, must be one of %s", source, strings.Join(availableSources, ", "))
+	}
+}
+
+// needsDB reports whether source requires a live MySQL connection.
+func needsDB(source string) bool {
+	return source != "slowlog"
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+	ini "github.com/vaughan0/go-ini"
+)
+
+// customTLSConfigName is the key -tls=custom registers its tls.Config
+// under via mysql.RegisterTLSConfig.
+const customTLSConfigName = "myprofiler-custom"
+
+// readClientSSLFromCnf reads the ssl-ca/ssl-cert/ssl-key settings from the
+// [client] section of a MySQL defaults file. mysql_defaults_file.Config has
+// no fields for them, so they're read separately here.
+func readClientSSLFromCnf(path string) (ca, cert, key string) {
+	file, err := ini.LoadFile(path)
+	if err != nil {
+		return "", "", ""
+	}
+	section := file["client"]
+	return section["ssl-ca"], section["ssl-cert"], section["ssl-key"]
+}
+
+// resolveTLS works out the DSN tls= value and, for -tls=custom, registers
+// the corresponding tls.Config. Any of tlsCA/tlsCert/tlsKey left blank on
+// the command line falls back to ~/.my.cnf's ssl-ca/ssl-cert/ssl-key.
+func resolveTLS(tlsMode, tlsCA, tlsCert, tlsKey string) (string, error) {
+	if home := os.Getenv("HOME"); tlsCA == "" && tlsCert == "" && tlsKey == "" {
+		if _, err := os.Stat(home + "/.my.cnf"); err == nil {
+			tlsCA, tlsCert, tlsKey = readClientSSLFromCnf(home + "/.my.cnf")
+		}
+	}
+
+	switch tlsMode {
+	case "false":
+		return "", nil
+	case "true", "skip-verify":
+		return tlsMode, nil
+	case "custom":
+		rootCertPool := x509.NewCertPool()
+		if tlsCA != "" {
+			pem, err := os.ReadFile(tlsCA)
+			if err != nil {
+				return "", err
+			}
+			if !rootCertPool.AppendCertsFromPEM(pem) {
+				return "", fmt.Errorf("failed to parse CA certificate from %s", tlsCA)
+			}
+		}
+		tlsConfig := &tls.Config{RootCAs: rootCertPool}
+		if tlsCert != "" && tlsKey != "" {
+			cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+			if err != nil {
+				return "", err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		if err := mysql.RegisterTLSConfig(customTLSConfigName, tlsConfig); err != nil {
+			return "", err
+		}
+		return customTLSConfigName, nil
+	default:
+		return "", fmt.Errorf("unknown -tls %q, must be one of false, true, skip-verify, custom", tlsMode)
+	}
+}